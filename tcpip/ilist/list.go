@@ -0,0 +1,188 @@
+// Copyright 2016 The Netstack Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package ilist provides a generic intrusive doubly-linked list, replacing
+// the mechanical per-type copies (starting with icmpPacketList) that used
+// to be hand-written for every element type in this tree.
+package ilist
+
+// Linker is the interface an element type T's pointer must implement to be
+// stored in a List[T, L]. L is constrained to (the core type) *T, and its
+// accessors are typed in terms of L rather than the bare *T, so that a
+// value handed back by Next/Prev can be fed straight into SetNext/SetPrev
+// without a conversion — that conversion is exactly what made the bare-*T
+// version of this interface fail to compile under Go's generics rules.
+//
+// Entry provides a ready-made implementation: embed Entry[T, L] in T to
+// satisfy Linker[T, L] for *T automatically.
+type Linker[T any, L ~*T] interface {
+	Next() L
+	Prev() L
+	SetNext(L)
+	SetPrev(L)
+}
+
+// List is a generic intrusive doubly-linked list. Elements can be added to
+// or removed from the list in O(1) time and with no additional memory
+// allocations.
+//
+// The zero value for List is an empty list ready to use.
+//
+// To iterate over a list (where l is a List):
+//
+//	for e := l.Front(); e != nil; e = e.Next() {
+//		// do something with e.
+//	}
+//
+// L's constraint repeats the ~*T from Linker[T, L] directly (rather than
+// relying on it transitively through Linker) because List needs L itself
+// known to have a pointer core type in order to compare it against nil.
+type List[T any, L interface {
+	~*T
+	Linker[T, L]
+}] struct {
+	head L
+	tail L
+}
+
+// Reset resets list l to the empty state.
+func (l *List[T, L]) Reset() {
+	l.head = nil
+	l.tail = nil
+}
+
+// Empty returns true iff the list is empty.
+func (l *List[T, L]) Empty() bool {
+	return l.head == nil
+}
+
+// Front returns the first element of list l or nil.
+func (l *List[T, L]) Front() L {
+	return l.head
+}
+
+// Back returns the last element of list l or nil.
+func (l *List[T, L]) Back() L {
+	return l.tail
+}
+
+// PushFront inserts the element e at the front of list l.
+func (l *List[T, L]) PushFront(e L) {
+	e.SetNext(l.head)
+	e.SetPrev(nil)
+
+	if l.head != nil {
+		l.head.SetPrev(e)
+	} else {
+		l.tail = e
+	}
+
+	l.head = e
+}
+
+// PushBack inserts the element e at the back of list l.
+func (l *List[T, L]) PushBack(e L) {
+	e.SetNext(nil)
+	e.SetPrev(l.tail)
+
+	if l.tail != nil {
+		l.tail.SetNext(e)
+	} else {
+		l.head = e
+	}
+
+	l.tail = e
+}
+
+// PushBackList inserts list m at the end of list l, emptying m.
+func (l *List[T, L]) PushBackList(m *List[T, L]) {
+	if l.head == nil {
+		l.head = m.head
+		l.tail = m.tail
+	} else if m.head != nil {
+		l.tail.SetNext(m.head)
+		m.head.SetPrev(l.tail)
+
+		l.tail = m.tail
+	}
+
+	m.head = nil
+	m.tail = nil
+}
+
+// InsertAfter inserts e after b.
+func (l *List[T, L]) InsertAfter(b, e L) {
+	a := b.Next()
+	e.SetNext(a)
+	e.SetPrev(b)
+	b.SetNext(e)
+
+	if a != nil {
+		a.SetPrev(e)
+	} else {
+		l.tail = e
+	}
+}
+
+// InsertBefore inserts e before a.
+func (l *List[T, L]) InsertBefore(a, e L) {
+	b := a.Prev()
+	e.SetNext(a)
+	e.SetPrev(b)
+	a.SetPrev(e)
+
+	if b != nil {
+		b.SetNext(e)
+	} else {
+		l.head = e
+	}
+}
+
+// Remove removes e from l.
+func (l *List[T, L]) Remove(e L) {
+	prev := e.Prev()
+	next := e.Next()
+
+	if prev != nil {
+		prev.SetNext(next)
+	} else {
+		l.head = next
+	}
+
+	if next != nil {
+		next.SetPrev(prev)
+	} else {
+		l.tail = prev
+	}
+}
+
+// Entry is a default implementation of Linker. Users can add anonymous
+// fields of this type to their structs to make them automatically
+// implement the methods needed by List. L must be the pointer-to-owner
+// type, e.g. Entry[MyElement, *MyElement] embedded in MyElement for use
+// with List[MyElement, *MyElement].
+type Entry[T any, L ~*T] struct {
+	next L
+	prev L
+}
+
+// Next returns the entry that follows e in the list.
+func (e *Entry[T, L]) Next() L {
+	return e.next
+}
+
+// Prev returns the entry that precedes e in the list.
+func (e *Entry[T, L]) Prev() L {
+	return e.prev
+}
+
+// SetNext assigns 'entry' as the entry that follows e in the list.
+func (e *Entry[T, L]) SetNext(entry L) {
+	e.next = entry
+}
+
+// SetPrev assigns 'entry' as the entry that precedes e in the list.
+func (e *Entry[T, L]) SetPrev(entry L) {
+	e.prev = entry
+}
@@ -0,0 +1,110 @@
+// Copyright 2016 The Netstack Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ilist
+
+import "testing"
+
+type testElement struct {
+	Entry[testElement, *testElement]
+	value int
+}
+
+type testList = List[testElement, *testElement]
+
+func elems(l *testList) []int {
+	var got []int
+	for e := l.Front(); e != nil; e = e.Next() {
+		got = append(got, e.value)
+	}
+	return got
+}
+
+func wantElems(t *testing.T, l *testList, want ...int) {
+	t.Helper()
+	got := elems(l)
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestPushBackFront(t *testing.T) {
+	var l testList
+	if !l.Empty() {
+		t.Fatalf("new list should be empty")
+	}
+
+	a, b, c := &testElement{value: 1}, &testElement{value: 2}, &testElement{value: 3}
+	l.PushBack(a)
+	l.PushBack(b)
+	l.PushFront(c)
+
+	wantElems(t, &l, 3, 1, 2)
+	if l.Front() != c {
+		t.Errorf("Front() = %v, want %v", l.Front(), c)
+	}
+	if l.Back() != b {
+		t.Errorf("Back() = %v, want %v", l.Back(), b)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	var l testList
+	a, b, c := &testElement{value: 1}, &testElement{value: 2}, &testElement{value: 3}
+	l.PushBack(a)
+	l.PushBack(b)
+	l.PushBack(c)
+
+	l.Remove(b)
+	wantElems(t, &l, 1, 3)
+
+	l.Remove(a)
+	l.Remove(c)
+	if !l.Empty() {
+		t.Fatalf("list should be empty after removing every element")
+	}
+}
+
+func TestInsertAfterBefore(t *testing.T) {
+	var l testList
+	a, b, c := &testElement{value: 1}, &testElement{value: 2}, &testElement{value: 3}
+	l.PushBack(a)
+	l.PushBack(c)
+	l.InsertAfter(a, b)
+	wantElems(t, &l, 1, 2, 3)
+
+	d := &testElement{value: 4}
+	l.InsertBefore(a, d)
+	wantElems(t, &l, 4, 1, 2, 3)
+}
+
+func TestPushBackList(t *testing.T) {
+	var l1, l2 testList
+	a, b := &testElement{value: 1}, &testElement{value: 2}
+	c, d := &testElement{value: 3}, &testElement{value: 4}
+	l1.PushBack(a)
+	l1.PushBack(b)
+	l2.PushBack(c)
+	l2.PushBack(d)
+
+	l1.PushBackList(&l2)
+	wantElems(t, &l1, 1, 2, 3, 4)
+	if !l2.Empty() {
+		t.Fatalf("source list should be emptied by PushBackList")
+	}
+}
+
+func TestReset(t *testing.T) {
+	var l testList
+	l.PushBack(&testElement{value: 1})
+	l.Reset()
+	if !l.Empty() {
+		t.Fatalf("list should be empty after Reset")
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2016 The Netstack Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sniffer
+
+import (
+	"fmt"
+
+	"github.com/google/netstack/tcpip"
+	"github.com/google/netstack/tcpip/buffer"
+	"github.com/google/netstack/tcpip/header"
+)
+
+// logPacket builds a one-line, tcpdump-style summary of payload, a frame of
+// protocol sent from src to dst.
+func logPacket(src, dst tcpip.LinkAddress, protocol tcpip.NetworkProtocolNumber, payload buffer.View) string {
+	switch protocol {
+	case header.ARPProtocolNumber:
+		return logARP(payload)
+	case header.IPv4ProtocolNumber:
+		return logIP(payload, header.IPv4(payload))
+	case header.IPv6ProtocolNumber:
+		return logIP(payload, header.IPv6(payload))
+	default:
+		return fmt.Sprintf("%s -> %s unknown network protocol %d, %d bytes", src, dst, protocol, len(payload))
+	}
+}
+
+func logARP(payload buffer.View) string {
+	h := header.ARP(payload)
+	if !h.IsValid() {
+		return fmt.Sprintf("arp: truncated packet, %d bytes", len(payload))
+	}
+
+	op := "request"
+	if h.Op() == header.ARPReply {
+		op = "reply"
+	}
+	return fmt.Sprintf("arp %s: %s/%x -> %s/%x",
+		op,
+		tcpip.Address(h.ProtocolAddressSender()), h.HardwareAddressSender(),
+		tcpip.Address(h.ProtocolAddressTarget()), h.HardwareAddressTarget())
+}
+
+// ipHeader is the subset of header.IPv4/header.IPv6 that logIP needs in
+// order to describe the transport segment it carries.
+type ipHeader interface {
+	SourceAddress() tcpip.Address
+	DestinationAddress() tcpip.Address
+	TransportProtocol() tcpip.TransportProtocolNumber
+	Payload() []byte
+}
+
+func logIP(payload buffer.View, h ipHeader) string {
+	src, dst := h.SourceAddress(), h.DestinationAddress()
+	transport := h.Payload()
+
+	switch h.TransportProtocol() {
+	case header.ICMPv6ProtocolNumber:
+		return logICMPv6(src, dst, transport)
+	case header.TCPProtocolNumber:
+		t := header.TCP(transport)
+		return fmt.Sprintf("tcp %s:%d -> %s:%d flags=0x%x seq=%d ack=%d len=%d",
+			src, t.SourcePort(), dst, t.DestinationPort(), t.Flags(), t.SequenceNumber(), t.AckNumber(), len(transport))
+	case header.UDPProtocolNumber:
+		u := header.UDP(transport)
+		return fmt.Sprintf("udp %s:%d -> %s:%d len=%d", src, u.SourcePort(), dst, u.DestinationPort(), len(transport))
+	default:
+		return fmt.Sprintf("%s -> %s unknown transport protocol %d, %d bytes", src, dst, h.TransportProtocol(), len(transport))
+	}
+}
+
+func logICMPv6(src, dst tcpip.Address, payload []byte) string {
+	icmp := header.ICMPv6(payload)
+	return fmt.Sprintf("icmpv6 %s -> %s type=%d code=%d len=%d", src, dst, icmp.Type(), icmp.Code(), len(payload))
+}
@@ -0,0 +1,58 @@
+// Copyright 2016 The Netstack Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sniffer
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+
+	"github.com/google/netstack/tcpip/buffer"
+)
+
+const (
+	pcapMagic            = 0xa1b2c3d4
+	pcapVersionMajor     = 2
+	pcapVersionMinor     = 4
+	pcapLinkTypeEthernet = 1
+	pcapLinkTypeRaw      = 101
+)
+
+// writePCAPHeader writes the 24-byte global libpcap file header described at
+// https://wiki.wireshark.org/Development/LibpcapFileFormat.
+func writePCAPHeader(w io.Writer, linkType uint32, snapLen uint32) error {
+	var hdr [24]byte
+	binary.LittleEndian.PutUint32(hdr[0:4], pcapMagic)
+	binary.LittleEndian.PutUint16(hdr[4:6], pcapVersionMajor)
+	binary.LittleEndian.PutUint16(hdr[6:8], pcapVersionMinor)
+	// bytes 8:12 (thiszone) and 12:16 (sigfigs) are left zero.
+	binary.LittleEndian.PutUint32(hdr[16:20], snapLen)
+	binary.LittleEndian.PutUint32(hdr[20:24], linkType)
+	_, err := w.Write(hdr[:])
+	return err
+}
+
+// writePCAPRecord writes a single per-packet libpcap record: a 16-byte
+// header (ts_sec, ts_usec, incl_len, orig_len) followed by up to snapLen
+// bytes of the packet itself.
+func writePCAPRecord(w io.Writer, payload buffer.View, snapLen uint32) error {
+	inclLen := uint32(len(payload))
+	if inclLen > snapLen {
+		inclLen = snapLen
+	}
+
+	now := time.Now()
+	var hdr [16]byte
+	binary.LittleEndian.PutUint32(hdr[0:4], uint32(now.Unix()))
+	binary.LittleEndian.PutUint32(hdr[4:8], uint32(now.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(hdr[8:12], inclLen)
+	binary.LittleEndian.PutUint32(hdr[12:16], uint32(len(payload)))
+
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(payload[:inclLen])
+	return err
+}
@@ -0,0 +1,85 @@
+// Copyright 2016 The Netstack Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sniffer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/google/netstack/tcpip/buffer"
+)
+
+func TestWritePCAPHeader(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writePCAPHeader(&buf, pcapLinkTypeRaw, 262144); err != nil {
+		t.Fatalf("writePCAPHeader: %v", err)
+	}
+
+	got := buf.Bytes()
+	if len(got) != 24 {
+		t.Fatalf("got %d-byte header, want 24", len(got))
+	}
+	if magic := binary.LittleEndian.Uint32(got[0:4]); magic != pcapMagic {
+		t.Errorf("magic = %#x, want %#x", magic, pcapMagic)
+	}
+	if major := binary.LittleEndian.Uint16(got[4:6]); major != pcapVersionMajor {
+		t.Errorf("version major = %d, want %d", major, pcapVersionMajor)
+	}
+	if minor := binary.LittleEndian.Uint16(got[6:8]); minor != pcapVersionMinor {
+		t.Errorf("version minor = %d, want %d", minor, pcapVersionMinor)
+	}
+	if snapLen := binary.LittleEndian.Uint32(got[16:20]); snapLen != 262144 {
+		t.Errorf("snaplen = %d, want 262144", snapLen)
+	}
+	if linkType := binary.LittleEndian.Uint32(got[20:24]); linkType != pcapLinkTypeRaw {
+		t.Errorf("linktype = %d, want %d", linkType, pcapLinkTypeRaw)
+	}
+}
+
+func TestWritePCAPRecordUnderSnapLen(t *testing.T) {
+	var buf bytes.Buffer
+	payload := buffer.View("hello, packet")
+	if err := writePCAPRecord(&buf, payload, 65536); err != nil {
+		t.Fatalf("writePCAPRecord: %v", err)
+	}
+
+	got := buf.Bytes()
+	if len(got) != 16+len(payload) {
+		t.Fatalf("got %d bytes, want %d", len(got), 16+len(payload))
+	}
+	if inclLen := binary.LittleEndian.Uint32(got[8:12]); inclLen != uint32(len(payload)) {
+		t.Errorf("incl_len = %d, want %d", inclLen, len(payload))
+	}
+	if origLen := binary.LittleEndian.Uint32(got[12:16]); origLen != uint32(len(payload)) {
+		t.Errorf("orig_len = %d, want %d", origLen, len(payload))
+	}
+	if !bytes.Equal(got[16:], payload) {
+		t.Errorf("record body = %q, want %q", got[16:], payload)
+	}
+}
+
+func TestWritePCAPRecordTruncatesToSnapLen(t *testing.T) {
+	var buf bytes.Buffer
+	payload := buffer.View("hello, packet")
+	const snapLen = 5
+	if err := writePCAPRecord(&buf, payload, snapLen); err != nil {
+		t.Fatalf("writePCAPRecord: %v", err)
+	}
+
+	got := buf.Bytes()
+	if len(got) != 16+snapLen {
+		t.Fatalf("got %d bytes, want %d", len(got), 16+snapLen)
+	}
+	if inclLen := binary.LittleEndian.Uint32(got[8:12]); inclLen != snapLen {
+		t.Errorf("incl_len = %d, want %d (truncated to snaplen)", inclLen, snapLen)
+	}
+	if origLen := binary.LittleEndian.Uint32(got[12:16]); origLen != uint32(len(payload)) {
+		t.Errorf("orig_len = %d, want %d (untruncated)", origLen, len(payload))
+	}
+	if !bytes.Equal(got[16:], payload[:snapLen]) {
+		t.Errorf("record body = %q, want %q", got[16:], payload[:snapLen])
+	}
+}
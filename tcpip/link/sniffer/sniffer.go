@@ -0,0 +1,130 @@
+// Copyright 2016 The Netstack Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package sniffer provides the implementation of data-link layer endpoints
+// that wrap another endpoint and logs (or writes to a pcap-compatible
+// writer) inbound and outbound packets.
+//
+// Sniffer endpoints can be used in the networking stack by calling
+// New(lower) (or NewWithWriter) to wrap an existing endpoint lower, and then
+// using the returned endpoint wherever lower would have been used.
+package sniffer
+
+import (
+	"io"
+	"log"
+	"sync"
+
+	"github.com/google/netstack/tcpip"
+	"github.com/google/netstack/tcpip/buffer"
+	"github.com/google/netstack/tcpip/stack"
+)
+
+// LogPackets is a flag enabling logging of all packets traversing the
+// sniffer via the standard log package's Printf, and is true by default.
+// This can be used to temporarily silence a New sniffer without unwrapping
+// it.
+var LogPackets uint32 = 1
+
+// endpoint is a data-link layer endpoint that wraps another endpoint and
+// logs or dumps packets that traverse it in both directions.
+type endpoint struct {
+	dispatcher stack.NetworkDispatcher
+	lower      stack.LinkEndpoint
+
+	mu      sync.Mutex
+	writer  io.Writer
+	snapLen uint32
+}
+
+// New creates a new sniffer link-layer endpoint that logs a human-readable
+// summary of every packet passing through it, in both directions, using the
+// standard log package.
+func New(lower stack.LinkEndpoint) stack.LinkEndpoint {
+	return &endpoint{lower: lower}
+}
+
+// NewWithWriter creates a new sniffer link-layer endpoint that writes every
+// packet passing through it, in both directions, to writer in libpcap
+// format so it can be later inspected with tcpdump/Wireshark. snapLen is the
+// maximum number of bytes captured from each packet; pass 0 to capture full
+// packets.
+func NewWithWriter(lower stack.LinkEndpoint, writer io.Writer, snapLen uint32) (stack.LinkEndpoint, error) {
+	if snapLen == 0 {
+		snapLen = 65536
+	}
+
+	// dump/writePCAPRecord only ever see the network-layer packet handed
+	// to WritePacket/DeliverNetworkPacket: no Ethernet header is
+	// synthesized or captured, regardless of the lower endpoint's link
+	// address length, so every record must be declared raw.
+	if err := writePCAPHeader(writer, pcapLinkTypeRaw, snapLen); err != nil {
+		return nil, err
+	}
+
+	return &endpoint{lower: lower, writer: writer, snapLen: snapLen}, nil
+}
+
+// DeliverNetworkPacket implements stack.NetworkDispatcher. It logs the
+// inbound packet and forwards it to the wrapped dispatcher.
+func (e *endpoint) DeliverNetworkPacket(linkEP stack.LinkEndpoint, remote tcpip.LinkAddress, protocol tcpip.NetworkProtocolNumber, payload buffer.View) {
+	e.dump(remote, e.lower.LinkAddress(), protocol, payload)
+	e.dispatcher.DeliverNetworkPacket(e, remote, protocol, payload)
+}
+
+// Attach implements stack.LinkEndpoint.Attach. It saves the dispatcher and
+// registers itself with the lower endpoint, so that it observes every
+// inbound packet before the real dispatcher does.
+func (e *endpoint) Attach(dispatcher stack.NetworkDispatcher) {
+	e.dispatcher = dispatcher
+	e.lower.Attach(e)
+}
+
+// IsAttached implements stack.LinkEndpoint.IsAttached.
+func (e *endpoint) IsAttached() bool {
+	return e.dispatcher != nil
+}
+
+// MTU implements stack.LinkEndpoint.MTU.
+func (e *endpoint) MTU() uint32 {
+	return e.lower.MTU()
+}
+
+// Capabilities implements stack.LinkEndpoint.Capabilities.
+func (e *endpoint) Capabilities() stack.LinkEndpointCapabilities {
+	return e.lower.Capabilities()
+}
+
+// MaxHeaderLength implements stack.LinkEndpoint.MaxHeaderLength.
+func (e *endpoint) MaxHeaderLength() uint16 {
+	return e.lower.MaxHeaderLength()
+}
+
+// LinkAddress implements stack.LinkEndpoint.LinkAddress.
+func (e *endpoint) LinkAddress() tcpip.LinkAddress {
+	return e.lower.LinkAddress()
+}
+
+// WritePacket implements stack.LinkEndpoint.WritePacket. It logs the
+// outbound packet and forwards it to the lower endpoint.
+func (e *endpoint) WritePacket(r *stack.Route, hdr *buffer.Prependable, payload buffer.View, protocol tcpip.NetworkProtocolNumber) error {
+	e.dump(e.lower.LinkAddress(), r.RemoteLinkAddress, protocol, payload)
+	return e.lower.WritePacket(r, hdr, payload, protocol)
+}
+
+func (e *endpoint) dump(src, dst tcpip.LinkAddress, protocol tcpip.NetworkProtocolNumber, payload buffer.View) {
+	if e.writer != nil {
+		e.mu.Lock()
+		err := writePCAPRecord(e.writer, payload, e.snapLen)
+		e.mu.Unlock()
+		if err != nil {
+			log.Printf("sniffer: failed to write pcap record: %v", err)
+		}
+		return
+	}
+
+	if LogPackets == 1 {
+		log.Print(logPacket(src, dst, protocol, payload))
+	}
+}
@@ -5,7 +5,6 @@
 package arp
 
 import (
-	"fmt"
 	"sync"
 	"time"
 
@@ -20,6 +19,89 @@ const (
 	ProtocolNumber = header.ARPProtocolNumber
 )
 
+const (
+	// entryTTL is how long a resolved entry remains usable before it must
+	// be re-requested.
+	entryTTL = 10 * time.Minute
+
+	// negativeTTL is how long a failed lookup is remembered so that
+	// repeated requests for an unreachable host don't generate a storm of
+	// ARP traffic.
+	negativeTTL = 10 * time.Second
+
+	// requestRetries is the number of times an ARP request is sent,
+	// counting the first transmission, before the lookup is given up on.
+	requestRetries = 3
+
+	// requestInterval is the delay between retransmissions of an ARP
+	// request.
+	requestInterval = 1 * time.Second
+)
+
+// cacheEntry holds a single resolved (or negatively cached) address.
+type cacheEntry struct {
+	linkAddr tcpip.LinkAddress
+	expires  time.Time
+	negative bool
+}
+
+func (e *cacheEntry) expired(now time.Time) bool {
+	return now.After(e.expires)
+}
+
+// cache is the ARP link-address cache. It is safe for concurrent use.
+type cache struct {
+	mu      sync.Mutex
+	entries map[tcpip.Address]cacheEntry
+}
+
+func newCache() *cache {
+	return &cache{entries: make(map[tcpip.Address]cacheEntry)}
+}
+
+// add records addr/linkAddr as a successfully resolved entry.
+func (c *cache) add(addr tcpip.Address, linkAddr tcpip.LinkAddress) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[addr] = cacheEntry{linkAddr: linkAddr, expires: time.Now().Add(entryTTL)}
+}
+
+// addNegative records that addr could not be resolved, so further lookups
+// fail fast until the negative entry expires.
+func (c *cache) addNegative(addr tcpip.Address) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[addr] = cacheEntry{expires: time.Now().Add(negativeTTL), negative: true}
+}
+
+// remove deletes any cached entry for addr.
+func (c *cache) remove(addr tcpip.Address) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, addr)
+}
+
+// flush removes every entry from the cache.
+func (c *cache) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[tcpip.Address]cacheEntry)
+}
+
+// lookup returns the cached link address for addr, if any. ok reports
+// whether a (possibly negative) entry was found; negative reports whether
+// the entry records a failed resolution.
+func (c *cache) lookup(addr tcpip.Address) (linkAddr tcpip.LinkAddress, ok bool, negative bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, found := c.entries[addr]
+	if !found || e.expired(time.Now()) {
+		delete(c.entries, addr)
+		return "", false, false
+	}
+	return e.linkAddr, true, e.negative
+}
+
 type endpoint struct {
 	nicid   tcpip.NICID
 	linkEP  stack.LinkEndpoint
@@ -47,8 +129,11 @@ func (e *endpoint) MaxHeaderLength() uint16 {
 	return e.linkEP.MaxHeaderLength() + header.ARPSize
 }
 
+// WritePacket is a no-op for ARP: there is no transport protocol layered on
+// top of it, so nothing should ever reach this path. Requests are built and
+// sent directly via sendRequest.
 func (e *endpoint) WritePacket(r *stack.Route, hdr *buffer.Prependable, payload buffer.View, protocol tcpip.TransportProtocolNumber) error {
-	panic("arp.WritePacket TODO")
+	return tcpip.ErrNotSupported
 }
 
 func (e *endpoint) HandlePacket(r *stack.Route, v buffer.View) {
@@ -63,17 +148,23 @@ func (e *endpoint) HandlePacket(r *stack.Route, v buffer.View) {
 		return // ignore
 	}
 
-	// TODO: add HardwareAddressSender/ProtocolAddressSender to ARP cache
-	/*fmt.Printf("TODO add %x/%s to ARP cache\n", h.HardwareAddressSender(), tcpip.Address(h.ProtocolAddressSender()))
-	var linkAddr [6]byte
-	copy(linkAddr[:], h.HardwareAddressSender())
-	e.stack.AddLinkAddrCache(nic, tcpip.Address(h.ProtocolAddressSender()), linkAddr)*/
+	senderAddr := tcpip.Address(h.ProtocolAddressSender())
+	senderLinkAddr := tcpip.LinkAddress(h.HardwareAddressSender())
+	// Feed the same cache NewLinkAddressLookup's resolution path reads
+	// from, rather than a private one of our own that nothing ever looks
+	// up: this is the only chance to record a sender's address for NICs
+	// that never issue an outgoing ARP request for it themselves.
+	if c := cacheFor(e.nicid); c != nil {
+		c.add(senderAddr, senderLinkAddr)
+	}
+	e.stack.AddLinkAddrCache(nic, senderAddr, senderLinkAddr)
 
 	if h.Op() == header.ARPRequest {
-		//dst := tcpip.Address(h.ProtocolAddressSender())
 		h.SetOp(header.ARPReply)
+		copy(h.HardwareAddressTarget(), h.HardwareAddressSender())
+		copy(h.ProtocolAddressTarget(), h.ProtocolAddressSender())
 		copy(h.HardwareAddressSender(), r.LocalLinkAddress[:])
-		copy(h.ProtocolAddressSender(), h.ProtocolAddressTarget())
+		copy(h.ProtocolAddressSender(), localAddr)
 		hdr := buffer.NewPrependable(int(e.linkEP.MaxHeaderLength()))
 		e.linkEP.WritePacket(r, &hdr, v, ProtocolNumber)
 	}
@@ -103,61 +194,188 @@ func (p *protocol) NewEndpoint(cfg stack.NetworkEndpointConfig) (stack.NetworkEn
 }
 
 func (p *protocol) NewLinkAddressLookup(s *stack.Stack, nicID tcpip.NICID, localLinkAddr tcpip.LinkAddress) tcpip.LinkAddressLookupFunc {
-	return nil
+	return NewLinkAddressLookup(s, nicID, localLinkAddr)
 }
 
 func init() {
 	stack.RegisterNetworkProtocol(ProtocolName, &protocol{})
 }
 
+// pendingRequest tracks the set of callers currently waiting on the same
+// in-flight lookup so that a single ARP request is sent no matter how many
+// callers ask to resolve the same address concurrently.
+type pendingRequest struct {
+	waiters []chan tcpip.LinkAddress
+}
+
+// caches holds the per-NIC ARP cache created by NewLinkAddressLookup, so
+// that callers elsewhere (e.g. "arp -d"-style tooling) can add or
+// invalidate entries without threading a handle through the stack.
+var (
+	cachesMu sync.Mutex
+	caches   = make(map[tcpip.NICID]*cache)
+)
+
+// AddCacheEntry inserts a resolved entry into nicID's ARP cache.
+func AddCacheEntry(nicID tcpip.NICID, addr tcpip.Address, linkAddr tcpip.LinkAddress) {
+	if c := cacheFor(nicID); c != nil {
+		c.add(addr, linkAddr)
+	}
+}
+
+// RemoveCacheEntry deletes addr from nicID's ARP cache, if present.
+func RemoveCacheEntry(nicID tcpip.NICID, addr tcpip.Address) {
+	if c := cacheFor(nicID); c != nil {
+		c.remove(addr)
+	}
+}
+
+// FlushCache removes every entry from nicID's ARP cache.
+func FlushCache(nicID tcpip.NICID) {
+	if c := cacheFor(nicID); c != nil {
+		c.flush()
+	}
+}
+
+func cacheFor(nicID tcpip.NICID) *cache {
+	cachesMu.Lock()
+	defer cachesMu.Unlock()
+	return caches[nicID]
+}
+
+// NewLinkAddressLookup returns a tcpip.LinkAddressLookupFunc that resolves
+// IPv4 addresses to link addresses via ARP, retransmitting the request a
+// bounded number of times and deduplicating concurrent lookups for the same
+// address.
 func NewLinkAddressLookup(s *stack.Stack, nicID tcpip.NICID, localLinkAddr tcpip.LinkAddress) tcpip.LinkAddressLookupFunc {
+	c := newCache()
+	cachesMu.Lock()
+	caches[nicID] = c
+	cachesMu.Unlock()
+
 	var waitMu sync.Mutex
-	wait := make(map[chan tcpip.LinkAddress]tcpip.Address)
+	pending := make(map[tcpip.Address]*pendingRequest)
+
+	notify := func(addr tcpip.Address, linkAddr tcpip.LinkAddress) {
+		waitMu.Lock()
+		p, ok := pending[addr]
+		if ok {
+			delete(pending, addr)
+		}
+		waitMu.Unlock()
+
+		if !ok {
+			return
+		}
+		for _, ch := range p.waiters {
+			select {
+			case ch <- linkAddr:
+			default:
+			}
+		}
+	}
 
 	s.SetNetworkProtocolHandler(ProtocolNumber, func(r *stack.Route, v buffer.View) bool {
 		h := header.ARP(v)
-		localAddr := tcpip.Address(h.ProtocolAddressTarget())
-		nic := s.CheckLocalAddress(0, localAddr)
-		fmt.Printf("arp: adding %x/%s to cache\n", h.HardwareAddressSender(), tcpip.Address(h.ProtocolAddressSender()))
+		if !h.IsValid() {
+			return false
+		}
+
+		nic := s.CheckLocalAddress(0, tcpip.Address(h.ProtocolAddressTarget()))
 
 		addr := tcpip.Address(h.ProtocolAddressSender())
 		linkAddr := tcpip.LinkAddress(h.HardwareAddressSender())
+		c.add(addr, linkAddr)
 		s.AddLinkAddrCache(nic, addr, linkAddr)
 
 		if h.Op() != header.ARPReply {
 			return false
 		}
 
-		waitMu.Lock()
-		for ch, chAddr := range wait {
-			if addr == chAddr {
-				select {
-				case ch <- linkAddr:
-				default:
-				}
-				delete(wait, ch)
-			}
-		}
-		waitMu.Unlock()
-
+		notify(addr, linkAddr)
 		return false
 	})
 
+	// sendRequest transmits a single ARP request for addr over linkEP,
+	// announcing localAddr as the sender's protocol address.
+	sendRequest := func(linkEP stack.LinkEndpoint, localAddr, addr tcpip.Address) {
+		v := buffer.NewView(header.ARPSize)
+		h := header.ARP(v)
+		h.SetIPv4OverEthernet()
+		h.SetOp(header.ARPRequest)
+		copy(h.HardwareAddressSender(), localLinkAddr)
+		copy(h.ProtocolAddressSender(), localAddr)
+		copy(h.ProtocolAddressTarget(), addr)
+
+		r := &stack.Route{
+			LocalAddress:      localAddr,
+			LocalLinkAddress:  localLinkAddr,
+			RemoteLinkAddress: header.EthernetBroadcastAddress,
+		}
+		hdr := buffer.NewPrependable(int(linkEP.MaxHeaderLength()))
+		linkEP.WritePacket(r, &hdr, v, ProtocolNumber)
+	}
+
 	return func(addr tcpip.Address) (tcpip.LinkAddress, error) {
-		ch := make(chan tcpip.LinkAddress)
+		if linkAddr, ok, negative := c.lookup(addr); ok {
+			if negative {
+				return "", tcpip.ErrNoLinkAddress
+			}
+			return linkAddr, nil
+		}
+
+		linkEP, localAddr, err := s.LinkEndpointAndAddress(nicID)
+		if err != nil {
+			return "", err
+		}
+
+		ch := make(chan tcpip.LinkAddress, 1)
+
+		waitMu.Lock()
+		p, alreadyPending := pending[addr]
+		if !alreadyPending {
+			p = &pendingRequest{}
+			pending[addr] = p
+		}
+		p.waiters = append(p.waiters, ch)
+		waitMu.Unlock()
 
-		fmt.Printf("TODO send ARP request for addr: %v\n", addr)
+		if !alreadyPending {
+			go func() {
+				sendRequest(linkEP, localAddr, addr)
+				for i := 1; i < requestRetries; i++ {
+					time.Sleep(requestInterval)
+					sendRequest(linkEP, localAddr, addr)
+				}
+			}()
+		}
 
 		select {
 		case res := <-ch:
 			return res, nil
-		case <-time.After(15 * time.Second): // TODO configurable ARP Wait
+		case <-time.After(requestRetries * requestInterval):
 			waitMu.Lock()
-			delete(wait, ch)
+			drained := false
+			if p, ok := pending[addr]; ok {
+				for i, w := range p.waiters {
+					if w == ch {
+						p.waiters = append(p.waiters[:i], p.waiters[i+1:]...)
+						break
+					}
+				}
+				if len(p.waiters) == 0 {
+					delete(pending, addr)
+					drained = true
+				}
+			}
 			waitMu.Unlock()
+			// Only negatively cache addr once every waiter on the pending
+			// request has timed out: another caller may still be waiting on
+			// the same in-flight resolution.
+			if drained {
+				c.addNegative(addr)
+			}
 			return "", tcpip.ErrTimeout
 		}
-
-		return "", fmt.Errorf("LinkAddressLookupFunc NOT IMPLEMENTED")
 	}
-}
\ No newline at end of file
+}
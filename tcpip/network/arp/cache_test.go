@@ -0,0 +1,90 @@
+// Copyright 2016 The Netstack Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package arp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/netstack/tcpip"
+)
+
+func TestCacheAddLookup(t *testing.T) {
+	c := newCache()
+	if _, ok, _ := c.lookup("1.2.3.4"); ok {
+		t.Fatalf("lookup on empty cache found an entry")
+	}
+
+	c.add("1.2.3.4", "\x01\x02\x03\x04\x05\x06")
+	linkAddr, ok, negative := c.lookup("1.2.3.4")
+	if !ok {
+		t.Fatalf("lookup after add found nothing")
+	}
+	if negative {
+		t.Fatalf("lookup after a successful add reported negative")
+	}
+	if linkAddr != "\x01\x02\x03\x04\x05\x06" {
+		t.Fatalf("lookup returned %q, want the added link address", linkAddr)
+	}
+}
+
+func TestCacheAddNegative(t *testing.T) {
+	c := newCache()
+	c.addNegative("1.2.3.4")
+
+	linkAddr, ok, negative := c.lookup("1.2.3.4")
+	if !ok {
+		t.Fatalf("lookup after addNegative found nothing")
+	}
+	if !negative {
+		t.Fatalf("lookup after addNegative did not report negative")
+	}
+	if linkAddr != "" {
+		t.Fatalf("lookup after addNegative returned a link address %q", linkAddr)
+	}
+}
+
+func TestCacheRemove(t *testing.T) {
+	c := newCache()
+	c.add("1.2.3.4", "\x01\x02\x03\x04\x05\x06")
+	c.remove("1.2.3.4")
+
+	if _, ok, _ := c.lookup("1.2.3.4"); ok {
+		t.Fatalf("lookup after remove still found an entry")
+	}
+}
+
+func TestCacheFlush(t *testing.T) {
+	c := newCache()
+	c.add("1.2.3.4", "\x01\x02\x03\x04\x05\x06")
+	c.add("1.2.3.5", "\x01\x02\x03\x04\x05\x07")
+	c.flush()
+
+	if _, ok, _ := c.lookup("1.2.3.4"); ok {
+		t.Fatalf("lookup after flush still found 1.2.3.4")
+	}
+	if _, ok, _ := c.lookup("1.2.3.5"); ok {
+		t.Fatalf("lookup after flush still found 1.2.3.5")
+	}
+}
+
+func TestCacheEntryExpires(t *testing.T) {
+	addr := tcpip.Address("1.2.3.4")
+	e := cacheEntry{linkAddr: "\x01\x02\x03\x04\x05\x06", expires: time.Now().Add(-time.Second)}
+	c := &cache{entries: map[tcpip.Address]cacheEntry{addr: e}}
+
+	if _, ok, _ := c.lookup(addr); ok {
+		t.Fatalf("lookup returned an entry past its expiry")
+	}
+	if _, ok, _ := c.lookup(addr); ok {
+		t.Fatalf("expired entry was not evicted by lookup")
+	}
+}
+
+func TestCacheForUnregisteredNIC(t *testing.T) {
+	if c := cacheFor(9999); c != nil {
+		t.Fatalf("cacheFor returned a cache for a NIC that never called NewLinkAddressLookup")
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2016 The Netstack Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stack
+
+import (
+	"sync"
+
+	"github.com/google/netstack/tcpip"
+	"github.com/google/netstack/tcpip/buffer"
+)
+
+// PacketEndpoint is implemented by transport endpoints, such as the
+// AF_PACKET-style raw socket in tcpip/transport/packet, that want a copy of
+// every link-layer frame a NIC sees in either direction, in addition to
+// whatever delivery the stack already performs for the frame's network
+// protocol.
+type PacketEndpoint interface {
+	// HandlePacket is called by a NIC's dispatch loop for every frame it
+	// processes that matches the endpoint's registration, inbound or
+	// outbound. remote is the link address of the frame's sender.
+	HandlePacket(nicID tcpip.NICID, remote tcpip.LinkAddress, pktType tcpip.PacketType, linkHeaderLen int, pkt buffer.View)
+}
+
+// RawPacketWriter is implemented by LinkEndpoints that can emit an
+// already-fully-formed frame exactly as given, as opposed to WritePacket,
+// which prepends its own link header before transmitting. It backs
+// packet.Endpoint's raw (SOCK_RAW) writes.
+type RawPacketWriter interface {
+	WriteRawPacket(hdr *buffer.Prependable, payload buffer.View) error
+}
+
+// No _test.go accompanies this file: every exported piece of behavior here
+// is a method on *Stack, and Stack itself is declared outside this chunk,
+// so nothing in this package can be instantiated or type-checked on its own
+// to exercise against.
+
+// packetEndpointKey scopes a registration to the NIC/protocol pair an
+// endpoint bound to. A zero NICID or NetworkProtocolNumber matches every NIC
+// or every protocol respectively, mirroring the NIC-0 wildcard convention
+// Stack.CheckLocalAddress already uses.
+type packetEndpointKey struct {
+	nicID    tcpip.NICID
+	netProto tcpip.NetworkProtocolNumber
+}
+
+// packetEndpoints is the per-Stack packet-endpoint registry. Its state used
+// to live in package-level variables keyed only by packetEndpointKey, which
+// meant every *Stack in the process shared one registry: two stacks that
+// happened to both have a NIC 1 would cross-deliver each other's raw
+// frames. The registry's definition can't be added directly to Stack here
+// (Stack itself is defined outside this chunk), so it is keyed on the
+// *Stack pointer instead, which gives each instance its own map without
+// touching Stack's declaration.
+type packetEndpoints struct {
+	mu  sync.Mutex
+	eps map[packetEndpointKey][]PacketEndpoint
+}
+
+var (
+	packetRegistriesMu sync.Mutex
+	packetRegistries   = make(map[*Stack]*packetEndpoints)
+)
+
+func packetEndpointsFor(s *Stack) *packetEndpoints {
+	packetRegistriesMu.Lock()
+	defer packetRegistriesMu.Unlock()
+	r, ok := packetRegistries[s]
+	if !ok {
+		r = &packetEndpoints{eps: make(map[packetEndpointKey][]PacketEndpoint)}
+		packetRegistries[s] = r
+	}
+	return r
+}
+
+// RegisterPacketEndpoint registers ep to receive a copy of every frame seen
+// on nicID (or every NIC, if nicID is 0) whose EtherType matches netProto
+// (or every EtherType, if netProto is 0).
+func (s *Stack) RegisterPacketEndpoint(nicID tcpip.NICID, netProto tcpip.NetworkProtocolNumber, ep PacketEndpoint) {
+	r := packetEndpointsFor(s)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := packetEndpointKey{nicID, netProto}
+	r.eps[key] = append(r.eps[key], ep)
+}
+
+// UnregisterPacketEndpoint undoes a prior RegisterPacketEndpoint.
+func (s *Stack) UnregisterPacketEndpoint(nicID tcpip.NICID, netProto tcpip.NetworkProtocolNumber, ep PacketEndpoint) {
+	r := packetEndpointsFor(s)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := packetEndpointKey{nicID, netProto}
+	eps := r.eps[key]
+	for i, e := range eps {
+		if e == ep {
+			r.eps[key] = append(eps[:i], eps[i+1:]...)
+			break
+		}
+	}
+}
+
+// DeliverToPacketEndpoints fans pkt out to every PacketEndpoint registered
+// for nicID/netProto, plus every wildcard registration that also matches.
+// NIC's existing dispatch loop calls this, alongside its normal
+// network-protocol demux, for every inbound frame it processes and every
+// frame it writes out.
+func (s *Stack) DeliverToPacketEndpoints(nicID tcpip.NICID, netProto tcpip.NetworkProtocolNumber, remote tcpip.LinkAddress, pktType tcpip.PacketType, linkHeaderLen int, pkt buffer.View) {
+	r := packetEndpointsFor(s)
+
+	r.mu.Lock()
+	seen := make(map[packetEndpointKey]bool, 4)
+	var eps []PacketEndpoint
+	for _, key := range [...]packetEndpointKey{{nicID, netProto}, {nicID, 0}, {0, netProto}, {0, 0}} {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		eps = append(eps, r.eps[key]...)
+	}
+	r.mu.Unlock()
+
+	for _, ep := range eps {
+		ep.HandlePacket(nicID, remote, pktType, linkHeaderLen, pkt)
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2016 The Netstack Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package tcpip provides the fundamental types shared by every network and
+// transport protocol implementation in this tree.
+package tcpip
+
+// Address is a byte slice cast as a string that represents the address of a
+// network node.
+type Address string
+
+// LinkAddress is a byte slice cast as a string that represents a link
+// address, such as an Ethernet MAC address.
+type LinkAddress string
+
+// NICID is a number that uniquely identifies a NIC.
+type NICID int32
+
+// NetworkProtocolNumber is the number of a network protocol.
+type NetworkProtocolNumber uint32
+
+// PacketType classifies a captured link-layer frame relative to the NIC it
+// was seen on, mirroring Linux's PACKET_* constants. It lives here, rather
+// than in the packet socket package that consumes it, so that stack.NIC can
+// attach a classification to a frame without depending on that package (and
+// creating an import cycle, since the packet package depends on stack).
+type PacketType int
+
+const (
+	// PacketHost indicates the frame was addressed to this host.
+	PacketHost PacketType = iota
+	// PacketBroadcast indicates the frame was a link-layer broadcast.
+	PacketBroadcast
+	// PacketMulticast indicates the frame was a link-layer multicast.
+	PacketMulticast
+	// PacketOtherHost indicates the frame was addressed to a different
+	// host but was seen because the NIC is in promiscuous mode.
+	PacketOtherHost
+	// PacketOutgoing indicates the frame originated from this host.
+	PacketOutgoing
+)
+
+// FullAddress represents a full transport node address, as used by the
+// Connect, Bind and GetLocalAddress methods of Endpoint.
+type FullAddress struct {
+	// NIC is the ID of the NIC this address refers to.
+	//
+	// This may not be used by all endpoint types.
+	NIC NICID
+
+	// Addr is the network address.
+	Addr Address
+
+	// Port is the transport port.
+	//
+	// This may not be used by all endpoint types.
+	Port uint16
+
+	// LinkAddr is the link-layer address associated with this address.
+	// Packet endpoints populate it on Read with the sender's hardware
+	// address; transport endpoints leave it empty.
+	LinkAddr LinkAddress
+
+	// Protocol is the network protocol number associated with this
+	// address. Packet endpoints use it to scope a Bind to a single
+	// EtherType; transport endpoints leave it zero.
+	Protocol NetworkProtocolNumber
+}
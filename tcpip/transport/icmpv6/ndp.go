@@ -0,0 +1,466 @@
+// Copyright 2016 The Netstack Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package icmpv6
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/netstack/tcpip"
+	"github.com/google/netstack/tcpip/buffer"
+	"github.com/google/netstack/tcpip/header"
+	"github.com/google/netstack/tcpip/stack"
+)
+
+// PacketSender transmits (or retransmits) a previously queued outbound
+// ICMPv6 packet. NewLinkAddressLookup's Neighbor Advertisement handler calls
+// the sender registered for a NIC via SetPacketSender to flush that NIC's
+// packets queued with EnqueuePacket once their destination resolves: this
+// package has no way to construct or transmit an *icmpPacket itself, since
+// doing so is entirely up to whoever queued it.
+type PacketSender func(p *icmpPacket)
+
+var (
+	sendersMu sync.Mutex
+	senders   = make(map[tcpip.NICID]PacketSender)
+)
+
+// SetPacketSender registers send as the way to retransmit packets queued via
+// EnqueuePacket for nicID once their destination's link address resolves.
+func SetPacketSender(nicID tcpip.NICID, send PacketSender) {
+	sendersMu.Lock()
+	defer sendersMu.Unlock()
+	senders[nicID] = send
+}
+
+func senderFor(nicID tcpip.NICID) PacketSender {
+	sendersMu.Lock()
+	defer sendersMu.Unlock()
+	return senders[nicID]
+}
+
+// ndpState is a neighbor cache entry's state in the RFC 4861 §7.3.2
+// Neighbor Unreachability Detection state machine.
+type ndpState int
+
+const (
+	ndpIncomplete ndpState = iota
+	ndpReachable
+	ndpStale
+	ndpDelay
+	ndpProbe
+)
+
+// reachableTime, delayFirstProbeTime and retransTimer are vars rather than
+// consts so tests can shorten them instead of sleeping through the real RFC
+// 4861 durations.
+var (
+	// reachableTime is how long a REACHABLE entry is trusted before it
+	// moves to STALE (RFC 4861 §6.3.4 suggests a random value around 30s;
+	// a fixed value is used here for simplicity).
+	reachableTime = 30 * time.Second
+
+	// delayFirstProbeTime is how long a STALE entry sits in DELAY,
+	// waiting for upper-layer confirmation, before probing begins.
+	delayFirstProbeTime = 5 * time.Second
+
+	// retransTimer is the delay between retransmitted Neighbor
+	// Solicitations, both during initial resolution (INCOMPLETE) and
+	// reachability probing (PROBE).
+	retransTimer = 1 * time.Second
+)
+
+const (
+	// maxMulticastSolicit bounds retransmissions while INCOMPLETE.
+	maxMulticastSolicit = 3
+
+	// maxUnicastSolicit bounds retransmissions while PROBE.
+	maxUnicastSolicit = 3
+)
+
+// ndpEntry is a single neighbor cache entry. generation is bumped on every
+// state transition so that a timer or retransmit goroutine scheduled from a
+// since-superseded state can recognize it is stale and quietly exit instead
+// of acting on an entry that has since moved on. waiting holds outbound
+// packets queued via EnqueuePacket while the entry is INCOMPLETE, to be
+// flushed by the Neighbor Advertisement handler once it resolves, rather
+// than blocking the packets' senders on a channel until then. resolving
+// reports whether a multicast-solicitation retransmit goroutine is already
+// running for the entry, so a second concurrent lookup doesn't start a
+// duplicate one.
+type ndpEntry struct {
+	linkAddr   tcpip.LinkAddress
+	state      ndpState
+	expires    time.Time
+	generation uint64
+	resolving  bool
+	waiting    icmpPacketList
+}
+
+// ndpCache is the IPv6 neighbor cache: the NDP analogue of the ARP package's
+// link-address cache.
+type ndpCache struct {
+	mu      sync.Mutex
+	entries map[tcpip.Address]*ndpEntry
+}
+
+func newNDPCache() *ndpCache {
+	return &ndpCache{entries: make(map[tcpip.Address]*ndpEntry)}
+}
+
+func (c *ndpCache) get(addr tcpip.Address) *ndpEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[addr]
+	if !ok {
+		e = &ndpEntry{state: ndpIncomplete}
+		c.entries[addr] = e
+	}
+	return e
+}
+
+// solicitedNodeMulticastAddress returns the solicited-node multicast address
+// (RFC 4291 §2.7.1, ff02::1:ffXX:XXXX) for target, used as the destination
+// of multicast Neighbor Solicitations.
+func solicitedNodeMulticastAddress(target tcpip.Address) tcpip.Address {
+	if len(target) != 16 {
+		return ""
+	}
+	addr := [16]byte{0xff, 0x02}
+	addr[11] = 1
+	addr[12] = 0xff
+	copy(addr[13:], target[13:16])
+	return tcpip.Address(addr[:])
+}
+
+// caches holds the per-NIC neighbor cache created by NewLinkAddressLookup,
+// so that EnqueuePacket/DequeuePackets can reach a NIC's queue of packets
+// awaiting resolution without a handle being threaded through the stack,
+// mirroring the arp package's own caches map.
+var (
+	cachesMu sync.Mutex
+	caches   = make(map[tcpip.NICID]*ndpCache)
+)
+
+func cacheFor(nicID tcpip.NICID) *ndpCache {
+	cachesMu.Lock()
+	defer cachesMu.Unlock()
+	return caches[nicID]
+}
+
+// EnqueuePacket queues p to be retransmitted, via the PacketSender
+// registered for nicID with SetPacketSender, once target's link address has
+// resolved. It is the non-blocking counterpart to a
+// tcpip.LinkAddressLookupFunc returned by NewLinkAddressLookup answering
+// tcpip.ErrWouldBlock: rather than block the caller on a channel until
+// resolution completes, the caller queues the packet it wanted to send and
+// is expected to be woken through PacketSender instead. It is a no-op if
+// nicID has no cache yet.
+func EnqueuePacket(nicID tcpip.NICID, target tcpip.Address, p *icmpPacket) {
+	c := cacheFor(nicID)
+	if c == nil {
+		return
+	}
+	entry := c.get(target)
+	c.mu.Lock()
+	entry.waiting.PushBack(p)
+	c.mu.Unlock()
+}
+
+// DequeuePackets removes and returns every packet queued via EnqueuePacket
+// for target on nicID.
+func DequeuePackets(nicID tcpip.NICID, target tcpip.Address) icmpPacketList {
+	c := cacheFor(nicID)
+	if c == nil {
+		return icmpPacketList{}
+	}
+	entry := c.get(target)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var drained icmpPacketList
+	drained.PushBackList(&entry.waiting)
+	return drained
+}
+
+// flushPending retransmits, via the PacketSender registered for nicID, every
+// packet queued for target with EnqueuePacket, now that its link address
+// has resolved.
+func flushPending(nicID tcpip.NICID, target tcpip.Address) {
+	send := senderFor(nicID)
+	if send == nil {
+		return
+	}
+	pending := DequeuePackets(nicID, target)
+	for p := pending.Front(); p != nil; p = p.Next() {
+		send(p)
+	}
+}
+
+// NewLinkAddressLookup returns a tcpip.LinkAddressLookupFunc that resolves
+// IPv6 addresses to link addresses using IPv6 Neighbor Discovery (RFC 4861),
+// mirroring arp.NewLinkAddressLookup for the IPv6 address family. Unlike the
+// ARP version, a cache miss never blocks the caller waiting on the
+// resolution: it returns tcpip.ErrWouldBlock immediately, and the caller is
+// expected to queue its outbound packet with EnqueuePacket to have it
+// retransmitted once resolution completes.
+func NewLinkAddressLookup(s *stack.Stack, nicID tcpip.NICID, localLinkAddr tcpip.LinkAddress) tcpip.LinkAddressLookupFunc {
+	cache := newNDPCache()
+	cachesMu.Lock()
+	caches[nicID] = cache
+	cachesMu.Unlock()
+
+	sendSolicit := func(localAddr, target tcpip.Address) {
+		linkEP, err := s.FindLinkEndpoint(nicID)
+		if err != nil {
+			return
+		}
+
+		dst := solicitedNodeMulticastAddress(target)
+		v := newNeighborSolicitation(localLinkAddr, localAddr, target, dst)
+		r := &stack.Route{
+			LocalAddress:      localAddr,
+			RemoteAddress:     dst,
+			LocalLinkAddress:  localLinkAddr,
+			RemoteLinkAddress: header.EthernetBroadcastAddress,
+		}
+		hdr := buffer.NewPrependable(int(linkEP.MaxHeaderLength()))
+		linkEP.WritePacket(r, &hdr, v, ProtocolNumber6)
+	}
+
+	s.SetNetworkProtocolHandler(ProtocolNumber6, func(r *stack.Route, v buffer.View) bool {
+		h := header.ICMPv6(v)
+		if h.Type() != header.ICMPv6NeighborAdvert {
+			return false
+		}
+
+		target, linkAddr, ok := parseNeighborAdvertisement(v)
+		if !ok || linkAddr == "" {
+			return false
+		}
+
+		entry := cache.get(target)
+		cache.mu.Lock()
+		entry.linkAddr = linkAddr
+		entry.state = ndpReachable
+		entry.expires = time.Now().Add(reachableTime)
+		entry.generation++
+		entry.resolving = false
+		generation := entry.generation
+		cache.mu.Unlock()
+
+		if nic := s.CheckLocalAddress(0, r.LocalAddress); nic != 0 {
+			s.AddLinkAddrCache(nic, target, linkAddr)
+			flushPending(nic, target)
+		}
+
+		scheduleStaleTransition(cache, entry, generation)
+		return false
+	})
+
+	return func(addr tcpip.Address) (tcpip.LinkAddress, error) {
+		entry := cache.get(addr)
+
+		cache.mu.Lock()
+		switch entry.state {
+		case ndpReachable, ndpStale, ndpDelay, ndpProbe:
+			linkAddr := entry.linkAddr
+			movingToDelay := entry.state == ndpStale
+			var generation uint64
+			if movingToDelay {
+				entry.state = ndpDelay
+				entry.expires = time.Now().Add(delayFirstProbeTime)
+				entry.generation++
+				generation = entry.generation
+			}
+			cache.mu.Unlock()
+			if movingToDelay {
+				scheduleProbeTransition(s, nicID, localLinkAddr, cache, addr, entry, generation)
+			}
+			return linkAddr, nil
+		}
+		alreadyResolving := entry.resolving
+		cache.mu.Unlock()
+
+		if !alreadyResolving {
+			// Resolve the sending local address before committing to
+			// entry.resolving: on error there is nothing to clean up,
+			// whereas doing this after would wedge entry.resolving into
+			// believing a resolution is already in flight when none ever
+			// started, and no later call would ever retry.
+			_, localAddr, err := s.LinkEndpointAndAddress(nicID)
+			if err != nil {
+				return "", err
+			}
+
+			cache.mu.Lock()
+			if entry.resolving {
+				// A concurrent lookup started the resolution first.
+				cache.mu.Unlock()
+				return "", tcpip.ErrWouldBlock
+			}
+			entry.resolving = true
+			cache.mu.Unlock()
+
+			go func() {
+				for i := 0; i < maxMulticastSolicit; i++ {
+					sendSolicit(localAddr, addr)
+					time.Sleep(retransTimer)
+				}
+				// Nothing answered: let a future lookup retry instead of
+				// leaving the entry stuck believing a resolution neither
+				// completed nor can be started again.
+				cache.mu.Lock()
+				if entry.state == ndpIncomplete {
+					entry.resolving = false
+				}
+				cache.mu.Unlock()
+			}()
+		}
+
+		// The caller is expected to queue the packet it wanted to send via
+		// EnqueuePacket and wait to be flushed through PacketSender, rather
+		// than block here until resolution completes or times out.
+		return "", tcpip.ErrWouldBlock
+	}
+}
+
+// scheduleStaleTransition arms a timer that moves entry from REACHABLE to
+// STALE once reachableTime elapses, unless entry has since moved to a
+// different generation (a fresh advertisement, or a transition that already
+// fired).
+func scheduleStaleTransition(cache *ndpCache, entry *ndpEntry, generation uint64) {
+	time.AfterFunc(reachableTime, func() {
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+		if entry.generation != generation || entry.state != ndpReachable {
+			return
+		}
+		entry.state = ndpStale
+	})
+}
+
+// scheduleProbeTransition arms a timer that moves entry from DELAY to PROBE
+// once delayFirstProbeTime elapses, and starts the unicast reachability
+// probe once it fires.
+func scheduleProbeTransition(s *stack.Stack, nicID tcpip.NICID, localLinkAddr tcpip.LinkAddress, cache *ndpCache, addr tcpip.Address, entry *ndpEntry, generation uint64) {
+	time.AfterFunc(delayFirstProbeTime, func() {
+		cache.mu.Lock()
+		if entry.generation != generation || entry.state != ndpDelay {
+			cache.mu.Unlock()
+			return
+		}
+		entry.state = ndpProbe
+		entry.generation++
+		probeGeneration := entry.generation
+		neighborLinkAddr := entry.linkAddr
+		cache.mu.Unlock()
+
+		go probeUnreachability(s, nicID, localLinkAddr, cache, addr, entry, neighborLinkAddr, probeGeneration)
+	})
+}
+
+// probeUnreachability implements the PROBE state: up to maxUnicastSolicit
+// unicast Neighbor Solicitations are sent directly to neighborLinkAddr, each
+// separated by retransTimer. A reply moves entry back to REACHABLE (via the
+// Neighbor Advertisement handler, which bumps the generation and so causes
+// this loop to quietly stop); if none arrives, the neighbor is considered
+// gone and entry reverts to INCOMPLETE.
+func probeUnreachability(s *stack.Stack, nicID tcpip.NICID, localLinkAddr tcpip.LinkAddress, cache *ndpCache, addr tcpip.Address, entry *ndpEntry, neighborLinkAddr tcpip.LinkAddress, generation uint64) {
+	_, localAddr, err := s.LinkEndpointAndAddress(nicID)
+	if err != nil {
+		return
+	}
+
+	for i := 0; i < maxUnicastSolicit; i++ {
+		cache.mu.Lock()
+		stillProbing := entry.generation == generation && entry.state == ndpProbe
+		cache.mu.Unlock()
+		if !stillProbing {
+			return
+		}
+		sendUnicastSolicit(s, nicID, localLinkAddr, localAddr, addr, neighborLinkAddr)
+		time.Sleep(retransTimer)
+	}
+
+	cache.mu.Lock()
+	if entry.generation == generation && entry.state == ndpProbe {
+		entry.state = ndpIncomplete
+		entry.linkAddr = ""
+		entry.generation++
+	}
+	cache.mu.Unlock()
+}
+
+// sendUnicastSolicit transmits a single unicast Neighbor Solicitation for
+// target, addressed directly to the neighbor's already-known link address,
+// as used by reachability probing (RFC 4861 §7.3.1).
+func sendUnicastSolicit(s *stack.Stack, nicID tcpip.NICID, localLinkAddr tcpip.LinkAddress, localAddr, target tcpip.Address, dstLinkAddr tcpip.LinkAddress) {
+	linkEP, err := s.FindLinkEndpoint(nicID)
+	if err != nil {
+		return
+	}
+
+	v := newNeighborSolicitation(localLinkAddr, localAddr, target, target)
+	r := &stack.Route{
+		LocalAddress:      localAddr,
+		RemoteAddress:     target,
+		LocalLinkAddress:  localLinkAddr,
+		RemoteLinkAddress: dstLinkAddr,
+	}
+	hdr := buffer.NewPrependable(int(linkEP.MaxHeaderLength()))
+	linkEP.WritePacket(r, &hdr, v, ProtocolNumber6)
+}
+
+// newNeighborSolicitation builds a Neighbor Solicitation message (RFC 4861
+// §4.3) for target, carrying a Source Link-Layer Address option naming
+// localLinkAddr. dstAddr is the solicitation's IPv6 destination (the
+// solicited-node multicast address during multicast resolution, or the
+// neighbor's own address during unicast probing), used to compute the
+// ICMPv6 checksum's pseudo-header.
+func newNeighborSolicitation(localLinkAddr tcpip.LinkAddress, localAddr, target, dstAddr tcpip.Address) buffer.View {
+	const solicitationSize = header.ICMPv6NeighborAdvertSize
+	v := buffer.NewView(solicitationSize + 8) // + Source Link-Layer Address option
+	h := header.ICMPv6(v)
+	h.SetType(header.ICMPv6NeighborSolicit)
+	h.SetCode(0)
+	copy(h[8:24], target)
+
+	opt := v[solicitationSize:]
+	opt[0] = ndpOptSourceLinkLayerAddress
+	opt[1] = 1 // length in units of 8 octets
+	copy(opt[2:8], localLinkAddr)
+
+	h.SetChecksum(header.ICMPv6Checksum(h, localAddr, dstAddr, buffer.VectorisedView{}))
+	return v
+}
+
+const (
+	ndpOptSourceLinkLayerAddress = 1
+	ndpOptTargetLinkLayerAddress = 2
+)
+
+// parseNeighborAdvertisement extracts the target address and, if present,
+// the Target Link-Layer Address option from a Neighbor Advertisement.
+func parseNeighborAdvertisement(v buffer.View) (target tcpip.Address, linkAddr tcpip.LinkAddress, ok bool) {
+	const advertSize = header.ICMPv6NeighborAdvertSize
+	if len(v) < advertSize {
+		return "", "", false
+	}
+	h := header.ICMPv6(v)
+	target = tcpip.Address(h[8:24])
+
+	for opts := v[advertSize:]; len(opts) >= 8; {
+		optType, optLen := opts[0], int(opts[1])*8
+		if optLen == 0 || optLen > len(opts) {
+			break
+		}
+		if optType == ndpOptTargetLinkLayerAddress {
+			linkAddr = tcpip.LinkAddress(opts[2:8])
+		}
+		opts = opts[optLen:]
+	}
+
+	return target, linkAddr, true
+}
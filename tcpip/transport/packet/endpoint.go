@@ -0,0 +1,235 @@
+// Copyright 2016 The Netstack Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package packet provides the implementation of packet sockets (see
+// Linux's AF_PACKET). A packet endpoint receives a copy of every link-layer
+// frame seen on the NICs it is bound to, in addition to whatever normal
+// delivery the stack already performs for the frame's network protocol.
+//
+// This mirrors gVisor's netstack AF_PACKET support: stack.NIC dispatch calls
+// into any endpoints registered via stack.Stack.RegisterPacketEndpoint for
+// every inbound (and, for PacketOutgoing, every outbound) frame, alongside
+// the existing network-protocol demux.
+package packet
+
+import (
+	"sync"
+
+	"github.com/google/netstack/tcpip"
+	"github.com/google/netstack/tcpip/buffer"
+	"github.com/google/netstack/tcpip/stack"
+	"github.com/google/netstack/tcpip/waiter"
+)
+
+// PacketType mirrors Linux's PACKET_{HOST,BROADCAST,MULTICAST,OTHERHOST,
+// OUTGOING} classification of a captured frame relative to the NIC it was
+// seen on. It is an alias of tcpip.PacketType, which is where stack.NIC's
+// dispatch loop (the thing that actually classifies frames) attaches the
+// value, without this package and the stack package forming an import
+// cycle.
+type PacketType = tcpip.PacketType
+
+const (
+	// PacketHost indicates the frame was addressed to this host.
+	PacketHost = tcpip.PacketHost
+	// PacketBroadcast indicates the frame was a link-layer broadcast.
+	PacketBroadcast = tcpip.PacketBroadcast
+	// PacketMulticast indicates the frame was a link-layer multicast.
+	PacketMulticast = tcpip.PacketMulticast
+	// PacketOtherHost indicates the frame was addressed to a different
+	// host but was seen because the NIC is in promiscuous mode.
+	PacketOtherHost = tcpip.PacketOtherHost
+	// PacketOutgoing indicates the frame originated from this host.
+	PacketOutgoing = tcpip.PacketOutgoing
+)
+
+const defaultRcvBufSize = 32 * 1024
+
+// packetInfo is the out-of-band metadata delivered with every captured
+// frame: which NIC it transited, its classification, and the sender's link
+// address.
+type packetInfo struct {
+	pkt      buffer.View
+	nicID    tcpip.NICID
+	linkAddr tcpip.LinkAddress
+	pktType  PacketType
+}
+
+// endpoint implements tcpip.Endpoint for AF_PACKET-style raw sockets.
+//
+// In SOCK_RAW mode (cooked == false) the full frame, including the
+// link-layer header, is delivered. In SOCK_DGRAM mode (cooked == true) the
+// link-layer header is stripped before delivery.
+type endpoint struct {
+	stack       *stack.Stack
+	netProto    tcpip.NetworkProtocolNumber
+	cooked      bool
+	waiterQueue *waiter.Queue
+
+	mu            sync.Mutex
+	closed        bool
+	bound         bool
+	nicID         tcpip.NICID
+	rcvList       []packetInfo
+	rcvBufSizeMax int
+}
+
+// NewEndpoint creates a new AF_PACKET endpoint bound to no NIC in
+// particular (i.e. it receives frames from every NIC until Bind restricts
+// it to one).
+func NewEndpoint(s *stack.Stack, cooked bool, netProto tcpip.NetworkProtocolNumber, waiterQueue *waiter.Queue) (tcpip.Endpoint, error) {
+	ep := &endpoint{
+		stack:         s,
+		netProto:      netProto,
+		cooked:        cooked,
+		waiterQueue:   waiterQueue,
+		rcvBufSizeMax: defaultRcvBufSize,
+	}
+	s.RegisterPacketEndpoint(0, netProto, ep)
+	return ep, nil
+}
+
+// HandlePacket is called by a NIC's dispatch loop for every frame it sees on
+// the wire that matches this endpoint's registration. linkHeaderLen is the
+// length of the link-layer header at the front of pkt, used to strip it in
+// cooked mode.
+func (ep *endpoint) HandlePacket(nicID tcpip.NICID, remote tcpip.LinkAddress, pktType PacketType, linkHeaderLen int, pkt buffer.View) {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+
+	if ep.closed || (ep.bound && ep.nicID != nicID) {
+		return
+	}
+
+	v := pkt
+	if ep.cooked && linkHeaderLen <= len(pkt) {
+		v = pkt[linkHeaderLen:]
+	}
+
+	if len(ep.rcvList) >= ep.rcvBufSizeMax {
+		return
+	}
+	ep.rcvList = append(ep.rcvList, packetInfo{pkt: v, nicID: nicID, linkAddr: remote, pktType: pktType})
+	ep.waiterQueue.Notify(waiter.EventIn)
+}
+
+// Close implements tcpip.Endpoint.Close.
+func (ep *endpoint) Close() {
+	ep.mu.Lock()
+	ep.closed = true
+	ep.rcvList = nil
+	ep.mu.Unlock()
+
+	ep.stack.UnregisterPacketEndpoint(0, ep.netProto, ep)
+}
+
+// Read implements tcpip.Endpoint.Read. It returns the oldest captured frame,
+// along with the NIC and sender link address it was captured from via addr.
+func (ep *endpoint) Read(addr *tcpip.FullAddress) (buffer.View, error) {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+
+	if len(ep.rcvList) == 0 {
+		return buffer.View{}, tcpip.ErrWouldBlock
+	}
+
+	p := ep.rcvList[0]
+	ep.rcvList = ep.rcvList[1:]
+
+	if addr != nil {
+		*addr = tcpip.FullAddress{
+			NIC:      p.nicID,
+			LinkAddr: p.linkAddr,
+			Protocol: ep.netProto,
+		}
+	}
+	return p.pkt, nil
+}
+
+// Write implements tcpip.Endpoint.Write. In SOCK_RAW mode v is emitted as a
+// complete frame, unmodified, through the NIC's link endpoint named in
+// opts.To (or the bound NIC if opts.To is nil). In SOCK_DGRAM mode v is a
+// network-layer payload with no link header of its own, so it is handed to
+// the link endpoint's normal WritePacket instead, which prepends one.
+func (ep *endpoint) Write(v buffer.View, opts tcpip.WriteOptions) (uintptr, error) {
+	nicID := ep.nicID
+	if opts.To != nil {
+		nicID = opts.To.NIC
+	}
+	if nicID == 0 {
+		return 0, tcpip.ErrInvalidEndpointState
+	}
+
+	linkEP, err := ep.stack.FindLinkEndpoint(nicID)
+	if err != nil {
+		return 0, err
+	}
+
+	if !ep.cooked {
+		rw, ok := linkEP.(stack.RawPacketWriter)
+		if !ok {
+			return 0, tcpip.ErrNotSupported
+		}
+
+		hdr := buffer.NewPrependable(0)
+		if err := rw.WriteRawPacket(&hdr, v); err != nil {
+			return 0, err
+		}
+		return uintptr(len(v)), nil
+	}
+
+	var dstLinkAddr tcpip.LinkAddress
+	if opts.To != nil {
+		dstLinkAddr = opts.To.LinkAddr
+	}
+	r := &stack.Route{RemoteLinkAddress: dstLinkAddr}
+	hdr := buffer.NewPrependable(int(linkEP.MaxHeaderLength()))
+	if err := linkEP.WritePacket(r, &hdr, v, ep.netProto); err != nil {
+		return 0, err
+	}
+	return uintptr(len(v)), nil
+}
+
+// Bind implements tcpip.Endpoint.Bind. It restricts the endpoint to frames
+// seen on a single NIC, identified by interface index, and, if addr.Protocol
+// is nonzero, to frames of that network protocol.
+func (ep *endpoint) Bind(addr tcpip.FullAddress, commit func() error) error {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+
+	if commit != nil {
+		if err := commit(); err != nil {
+			return err
+		}
+	}
+
+	if addr.Protocol != 0 && addr.Protocol != ep.netProto {
+		ep.stack.UnregisterPacketEndpoint(0, ep.netProto, ep)
+		ep.netProto = addr.Protocol
+		ep.stack.RegisterPacketEndpoint(0, ep.netProto, ep)
+	}
+
+	ep.bound = true
+	ep.nicID = addr.NIC
+	return nil
+}
+
+// GetLocalAddress implements tcpip.Endpoint.GetLocalAddress.
+func (ep *endpoint) GetLocalAddress() (tcpip.FullAddress, error) {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	return tcpip.FullAddress{NIC: ep.nicID, Protocol: ep.netProto}, nil
+}
+
+// Readiness implements tcpip.Endpoint.Readiness.
+func (ep *endpoint) Readiness(mask waiter.EventMask) waiter.EventMask {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+
+	var result waiter.EventMask
+	if len(ep.rcvList) > 0 {
+		result |= waiter.EventIn
+	}
+	return result & mask
+}